@@ -11,6 +11,10 @@ import (
 type TableSchema struct {
 	Name string `json:"name"`
 	SQL  string `json:"sql"`
+	// RootPage is the table's root b-tree page number, used by the repair
+	// package's TraverseMode to walk each table's pages instead of
+	// guessing row ownership from a blind linear scan.
+	RootPage int `json:"rootpage"`
 }
 
 func ExportSchema(dbPath string, backupPath string) error {
@@ -20,7 +24,7 @@ func ExportSchema(dbPath string, backupPath string) error {
 	}
 	defer db.Close()
 
-	rows, err := db.Query("SELECT name, sql FROM sqlite_master WHERE type='table' AND sql IS NOT NULL AND name NOT LIKE 'sqlite_%'")
+	rows, err := db.Query("SELECT name, sql, rootpage FROM sqlite_master WHERE type='table' AND sql IS NOT NULL AND name NOT LIKE 'sqlite_%'")
 	if err != nil {
 		return err
 	}
@@ -29,7 +33,7 @@ func ExportSchema(dbPath string, backupPath string) error {
 	var schemas []TableSchema
 	for rows.Next() {
 		var s TableSchema
-		if err := rows.Scan(&s.Name, &s.SQL); err != nil {
+		if err := rows.Scan(&s.Name, &s.SQL, &s.RootPage); err != nil {
 			return err
 		}
 		schemas = append(schemas, s)
@@ -32,6 +32,7 @@ func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  sqlite-repair backup <db_path> <schema_output_path>")
 	fmt.Println("  sqlite-repair repair -src <corrupt_db> -schema <schema_path> -out <output_db> [-pagesize 4096]")
+	fmt.Println("  sqlite-repair repair -src <corrupt_db> -schemaless -out <output_db> [-pagesize 4096]")
 	fmt.Println("  sqlite-repair check <db_path>")
 }
 
@@ -67,12 +68,16 @@ func handleRepair() {
 	repairCmd := flag.NewFlagSet("repair", flag.ExitOnError)
 	src := repairCmd.String("src", "", "Path to corrupt database")
 	schema := repairCmd.String("schema", "", "Path to backup schema json")
+	schemaless := repairCmd.Bool("schemaless", false, "Recover table schemas from the corrupt file instead of requiring -schema")
+	recoverDeleted := repairCmd.Bool("recover-deleted", false, "Also salvage deleted rows from freeblocks/slack into __deleted_<table> tables")
+	traverse := repairCmd.Bool("traverse", false, "Walk each table's b-tree from its root page instead of a blind linear scan, when root pages are known")
+	strict := repairCmd.Bool("strict", false, "Abort on the first corruption found instead of continuing best-effort")
 	out := repairCmd.String("out", "", "Path to output recovered database")
 	pageSize := repairCmd.Int("pagesize", 4096, "Page size of the database (default 4096)")
 
 	repairCmd.Parse(os.Args[2:])
 
-	if *src == "" || *schema == "" || *out == "" {
+	if *src == "" || *out == "" || (*schema == "" && !*schemaless) {
 		repairCmd.Usage()
 		os.Exit(1)
 	}
@@ -82,6 +87,10 @@ func handleRepair() {
 		BackupSchemaPath: *schema,
 		OutputDBPath:     *out,
 		PageSize:         *pageSize,
+		SchemalessMode:   *schemaless,
+		RecoverDeleted:   *recoverDeleted,
+		TraverseMode:     *traverse,
+		Strict:           *strict,
 	}
 
 	if err := repair.Run(cfg); err != nil {
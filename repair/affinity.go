@@ -0,0 +1,206 @@
+package repair
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Affinity is one of SQLite's five column affinities (file format spec
+// §3.1), used to score how well a decoded value fits a candidate column
+// instead of matching tables on column count alone.
+type Affinity int
+
+const (
+	AffinityBlob Affinity = iota
+	AffinityText
+	AffinityNumeric
+	AffinityInteger
+	AffinityReal
+)
+
+// columnAffinity derives a column's affinity from its declared type per the
+// rules in SQLite file format spec §3.1: the first matching substring (in
+// the order below) wins, an empty declared type is BLOB, and a declared type
+// with no match at all is NUMERIC.
+func columnAffinity(declType string) Affinity {
+	t := strings.ToUpper(declType)
+	switch {
+	case strings.Contains(t, "INT"):
+		return AffinityInteger
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return AffinityText
+	case strings.Contains(t, "BLOB"), t == "":
+		return AffinityBlob
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return AffinityReal
+	default:
+		return AffinityNumeric
+	}
+}
+
+// valueAffinity reports the affinity of a value as decoded by
+// parseSerialType, and whether it was a SQL NULL (serial type 0).
+func valueAffinity(v interface{}) (affinity Affinity, isNull bool) {
+	switch val := v.(type) {
+	case nil:
+		return AffinityBlob, true
+	case int64:
+		return AffinityInteger, false
+	case float64:
+		return AffinityReal, false
+	case string:
+		if _, err := strconv.ParseFloat(val, 64); err == nil {
+			return AffinityNumeric, false
+		}
+		return AffinityText, false
+	case []byte:
+		return AffinityBlob, false
+	default:
+		return AffinityBlob, false
+	}
+}
+
+// disqualifyScore marks a value/column pairing that SQLite itself would
+// reject (a NOT NULL column fed NULL, or a value with no sane coercion into
+// the column's affinity), steering scoreTable away from the table entirely
+// rather than just docking it a couple of points.
+const disqualifyScore = -1000
+
+// scoreColumn scores how well value fits col, per the column-affinity rules
+// in SQLite file format spec §3.1: an exact affinity match scores highest,
+// a coercible mismatch (e.g. an INTEGER value into a NUMERIC column) scores
+// a little, and a mismatch SQLite couldn't store at all disqualifies the
+// whole candidate table.
+func scoreColumn(value interface{}, col ColumnInfo) int {
+	colAff := columnAffinity(col.Type)
+	valAff, isNull := valueAffinity(value)
+
+	if isNull {
+		if col.NotNull {
+			return disqualifyScore
+		}
+		return 0
+	}
+
+	if valAff == colAff {
+		return 2
+	}
+
+	switch colAff {
+	case AffinityNumeric:
+		if valAff == AffinityInteger || valAff == AffinityReal {
+			return 1
+		}
+		if valAff == AffinityText {
+			// scoreColumn only sees AffinityText here when the string
+			// failed to parse as a number (see valueAffinity), so a
+			// NOT NULL NUMERIC column can't actually hold it.
+			if col.NotNull {
+				return disqualifyScore
+			}
+			return -1
+		}
+	case AffinityInteger, AffinityReal:
+		if valAff == AffinityInteger || valAff == AffinityReal || valAff == AffinityNumeric {
+			return 1
+		}
+		if valAff == AffinityBlob {
+			return disqualifyScore
+		}
+	case AffinityText:
+		if valAff == AffinityNumeric {
+			return 1
+		}
+	case AffinityBlob:
+		if valAff == AffinityInteger || valAff == AffinityReal || valAff == AffinityNumeric {
+			return disqualifyScore
+		}
+	}
+
+	return -1
+}
+
+// scoreTable scores how well values fits table as a whole: the sum of each
+// value's column score, or disqualifyScore as soon as any pairing is one
+// SQLite couldn't actually store. partial rows (a truncated overflow chain
+// cut the record short) leave trailing columns to become NULL, so a
+// trailing NOT NULL column disqualifies a partial match the same way an
+// explicit NULL would.
+func scoreTable(values []interface{}, table TableInfo, partial bool) int {
+	score := 0
+	for i, col := range table.Columns {
+		if i >= len(values) {
+			if !partial {
+				return disqualifyScore
+			}
+			if col.NotNull {
+				return disqualifyScore
+			}
+			continue
+		}
+		s := scoreColumn(values[i], col)
+		if s == disqualifyScore {
+			return disqualifyScore
+		}
+		score += s
+	}
+	return score
+}
+
+// matchThreshold is the minimum scoreTable result a table must clear to be
+// considered a real match; at or below it, the row is routed to an
+// __unmatched_<n>cols bucket instead of guessed into a table that merely
+// didn't disqualify itself.
+const matchThreshold = 0
+
+// serialSignature renders a record's serial-type header as a comparable key,
+// so that once a page's first row of a given shape picks a table, later rows
+// with the identical shape on the same page can be nudged toward it instead
+// of re-litigating affinity scores from scratch.
+func serialSignature(types []uint64) string {
+	var b strings.Builder
+	for i, t := range types {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatUint(t, 10))
+	}
+	return b.String()
+}
+
+// signaturePreferenceBonus is added to a table's score when it was the table
+// a prior row with the same serial-type signature on this page matched.
+const signaturePreferenceBonus = 3
+
+// bestTable scores values against every candidate table, applying
+// signaturePreferenceBonus to whichever table page previously matched sig,
+// and returns the best match along with its score. ok is false when tables
+// is empty or every candidate disqualified itself.
+func bestTable(values []interface{}, types []uint64, partial bool, tables []TableInfo, preferred map[string]string) (table TableInfo, score int, ok bool) {
+	sig := serialSignature(types)
+	preferredName := preferred[sig]
+
+	bestScore := disqualifyScore
+	var best TableInfo
+	found := false
+
+	for _, t := range tables {
+		if !candidateMatches(values, t, partial) {
+			continue
+		}
+		s := scoreTable(values, t, partial)
+		if s == disqualifyScore {
+			continue
+		}
+		if t.Name == preferredName {
+			s += signaturePreferenceBonus
+		}
+		if !found || s > bestScore {
+			bestScore = s
+			best = t
+			found = true
+		}
+	}
+
+	return best, bestScore, found
+}
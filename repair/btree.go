@@ -0,0 +1,106 @@
+package repair
+
+import "encoding/binary"
+
+// B-tree page type flags, per the SQLite file format spec §1.5.
+const (
+	pageTypeInteriorIndex = 0x02
+	pageTypeInteriorTable = 0x05
+	pageTypeLeafIndex     = 0x0A
+	pageTypeLeafTable     = 0x0D
+)
+
+// sqliteMasterPageIndex is the 0-indexed page carrying sqlite_master's own
+// b-tree root, which the file format spec fixes at page 1 for every database.
+const sqliteMasterPageIndex = 0
+
+// pageHeaderOffset returns where a page's b-tree header begins: page 1
+// (pageIndex 0) carries the 100-byte database header first.
+func pageHeaderOffset(pageIndex int) int {
+	if pageIndex == 0 {
+		return 100
+	}
+	return 0
+}
+
+// pageFlag returns the b-tree page-type byte at headerOffset, or ok=false if
+// the page is too short to have one.
+func pageFlag(pageData []byte, headerOffset int) (flag byte, ok bool) {
+	if headerOffset >= len(pageData) {
+		return 0, false
+	}
+	return pageData[headerOffset], true
+}
+
+// interiorChildPages returns every child page pointer referenced by an
+// interior b-tree page (table 0x05 or index 0x02): the left-child pointer
+// of each cell, plus the header's right-most pointer. A cell's payload
+// beyond that first 4-byte pointer is a rowid (table) or an index key
+// record (index) - neither is needed to walk the page graph, only to read
+// the rows once a leaf is reached.
+func interiorChildPages(pageData []byte, headerOffset int) []uint32 {
+	if headerOffset+12 > len(pageData) {
+		return nil
+	}
+	numCells := binary.BigEndian.Uint16(pageData[headerOffset+3 : headerOffset+5])
+
+	children := make([]uint32, 0, numCells+1)
+	for i := 0; i < int(numCells); i++ {
+		ptrOffset := headerOffset + 12 + i*2
+		if ptrOffset+2 > len(pageData) {
+			break
+		}
+		cellOffset := binary.BigEndian.Uint16(pageData[ptrOffset : ptrOffset+2])
+		if int(cellOffset)+4 > len(pageData) {
+			continue
+		}
+		children = append(children, binary.BigEndian.Uint32(pageData[cellOffset:cellOffset+4]))
+	}
+
+	rightMost := binary.BigEndian.Uint32(pageData[headerOffset+8 : headerOffset+12])
+	children = append(children, rightMost)
+	return children
+}
+
+// traverseTable performs a depth-first walk of a table's b-tree starting at
+// rootPage, calling onLeaf for every leaf table page (0x0D) it reaches.
+// Every page visited - leaf or interior - is recorded in reached, both to
+// avoid revisiting a page twice (corruption can turn the tree into a graph
+// with cycles) and so Run knows which pages were already claimed by a known
+// table before falling back to a linear scan for the rest.
+func traverseTable(pf *pageFetcher, rootPage uint32, reached map[uint32]bool, onLeaf func(pageIndex int, pageData []byte)) {
+	stack := []uint32{rootPage}
+	for len(stack) > 0 {
+		page := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if page == 0 || reached[page] {
+			continue
+		}
+		reached[page] = true
+
+		data, err := pf.readPage(page)
+		if err != nil {
+			continue
+		}
+
+		headerOffset := pageHeaderOffset(int(page - 1))
+		flag, ok := pageFlag(data, headerOffset)
+		if !ok {
+			continue
+		}
+
+		switch flag {
+		case pageTypeLeafTable:
+			onLeaf(int(page-1), data)
+		case pageTypeInteriorTable:
+			stack = append(stack, interiorChildPages(data, headerOffset)...)
+		default:
+			// An index page (0x0A/0x02) reached from a table's root means
+			// the root page is stale or corruption pointed us somewhere
+			// wrong; nothing to recover from it as a table row, and it's
+			// still marked reached so the linear-scan fallback doesn't
+			// waste time reinterpreting it as one either.
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package repair
+
+import "fmt"
+
+// CorruptionKind classifies the shape of structural damage a CorruptionError
+// describes, modeled after leveldb's errors.IsCorrupted taxonomy so callers
+// can filter and aggregate findings without parsing error strings.
+type CorruptionKind int
+
+const (
+	// BadPageHeader means a page was too short for, or failed to carry, a
+	// recognizable b-tree page header.
+	BadPageHeader CorruptionKind = iota
+	// BadCellPointer means a cell pointer in a page's cell-pointer array
+	// pointed outside the page.
+	BadCellPointer
+	// TruncatedVarint means a varint ran off the end of its buffer, or a
+	// record's declared header length ran off the end of its payload.
+	TruncatedVarint
+	// BadSerialType means a record's body didn't have enough bytes left for
+	// a serial type's declared width, or the serial type itself is unknown.
+	BadSerialType
+	// PayloadOverflowMissing means a cell's payload exceeded what fits
+	// locally but its overflow chain was missing, truncated, or cyclic.
+	PayloadOverflowMissing
+	// RecordArityMismatch means a decoded row didn't score a real match
+	// against any known table and was routed to an __unmatched_<n>cols
+	// bucket instead.
+	RecordArityMismatch
+	// ChecksumMismatch means a WAL frame or rollback-journal page checksum
+	// didn't verify, ending the valid portion of that sidecar file.
+	ChecksumMismatch
+)
+
+func (k CorruptionKind) String() string {
+	switch k {
+	case BadPageHeader:
+		return "BadPageHeader"
+	case BadCellPointer:
+		return "BadCellPointer"
+	case TruncatedVarint:
+		return "TruncatedVarint"
+	case BadSerialType:
+		return "BadSerialType"
+	case PayloadOverflowMissing:
+		return "PayloadOverflowMissing"
+	case RecordArityMismatch:
+		return "RecordArityMismatch"
+	case ChecksumMismatch:
+		return "ChecksumMismatch"
+	default:
+		return "Unknown"
+	}
+}
+
+// CorruptionError describes one instance of structural damage found while
+// repairing a database. PageIndex and Offset are -1 when the layer that
+// detected the problem didn't have page context (e.g. a serial type decoded
+// from a bare byte slice); callers closer to the page loop fill them in via
+// At before reporting.
+type CorruptionError struct {
+	PageIndex int
+	Offset    int
+	Kind      CorruptionKind
+	Detail    string
+}
+
+func newCorruptionError(kind CorruptionKind, detail string) *CorruptionError {
+	return &CorruptionError{PageIndex: -1, Offset: -1, Kind: kind, Detail: detail}
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("page %d offset %d: %s: %s", e.PageIndex, e.Offset, e.Kind, e.Detail)
+}
+
+// At returns a copy of e with page context filled in, for a lower layer's
+// error to be enriched once the caller that has that context reports it.
+func (e *CorruptionError) At(pageIndex, offset int) *CorruptionError {
+	cp := *e
+	cp.PageIndex = pageIndex
+	cp.Offset = offset
+	return &cp
+}
+
+// Reporter receives structured events as Run progresses, so callers can
+// emit JSON/NDJSON forensic reports instead of scraping stdout.
+type Reporter interface {
+	OnCorruption(CorruptionError)
+	OnRecovered(table string, rowID uint64)
+	OnPage(pageIndex int, kind byte)
+}
+
+// reportCorruption notifies cfg.Reporter (if set) and prints a console
+// message either way, matching this package's existing best-effort warning
+// style. It returns ce so callers can both report and propagate in one line.
+func reportCorruption(cfg RepairConfig, ce *CorruptionError) *CorruptionError {
+	if cfg.Reporter != nil {
+		cfg.Reporter.OnCorruption(*ce)
+	}
+	fmt.Printf("Corruption: %v\n", ce)
+	return ce
+}
+
+func reportRecovered(cfg RepairConfig, table string, rowID uint64) {
+	if cfg.Reporter != nil {
+		cfg.Reporter.OnRecovered(table, rowID)
+	}
+}
+
+func reportPage(cfg RepairConfig, pageIndex int, kind byte) {
+	if cfg.Reporter != nil {
+		cfg.Reporter.OnPage(pageIndex, kind)
+	}
+}
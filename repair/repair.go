@@ -4,9 +4,10 @@ import (
 	"database/sql"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/tougee/sqlite-repair-go/backup"
@@ -17,23 +18,196 @@ type RepairConfig struct {
 	BackupSchemaPath string
 	OutputDBPath     string
 	PageSize         int // Usually 4096 for SQLite
+
+	// SchemalessMode forces schema recovery from CorruptDBPath even when
+	// BackupSchemaPath is also set, so the recovered schema can be
+	// cross-checked against the backup.
+	SchemalessMode bool
+
+	// RecoverDeleted additionally scans freeblocks and unused cell-area slack
+	// on each leaf table page for record fragments left behind by DELETE.
+	// Anything found is inserted into a shadow __deleted_<table> table
+	// rather than the live table, so it can be reviewed without polluting it.
+	RecoverDeleted bool
+
+	// TraverseMode, when a table's root page is known, walks that table's
+	// b-tree from its root instead of matching rows against every table
+	// during a blind linear scan. Pages only reachable this way are
+	// attempted against the single owning table, eliminating false
+	// positives from other tables that happen to share its row shape.
+	// Pages not reachable from any root (e.g. orphaned by corruption) still
+	// fall back to the linear scan.
+	TraverseMode bool
+
+	// Reporter, if set, receives every corruption finding, recovered row,
+	// and visited page as structured events instead of the plain-text
+	// progress this package prints to stdout regardless.
+	Reporter Reporter
+
+	// Strict aborts Run as soon as any CorruptionError is found, instead of
+	// the default best-effort behavior of recovering whatever is readable.
+	// Useful for CI/verification runs where a clean bill of health matters
+	// more than maximizing recovered rows. The Reporter still receives
+	// every event up to the abort.
+	Strict bool
 }
 
 type TableInfo struct {
-	Name    string
-	Columns []ColumnInfo
+	Name     string
+	Columns  []ColumnInfo
+	RootPage int // 0 if unknown
 }
 
 type ColumnInfo struct {
-	Name string
-	Type string
-	Pk   int // 1 if PK
+	Name    string
+	Type    string
+	Pk      int // 1 if PK
+	NotNull bool
+}
+
+// pageFetcher gives random access to pages of the corrupt database file.
+type pageFetcher struct {
+	r        *os.File
+	pageSize int
+	overlay  *WALOverlay
+}
+
+func newPageFetcher(r *os.File, pageSize int, overlay *WALOverlay) *pageFetcher {
+	return &pageFetcher{r: r, pageSize: pageSize, overlay: overlay}
+}
+
+// readPage fetches the 1-indexed SQLite page pageNum, preferring the replayed
+// WAL/journal overlay (if any) over the main file, since the overlay holds
+// the latest committed image for pages it covers.
+func (pf *pageFetcher) readPage(pageNum uint32) ([]byte, error) {
+	if pageNum == 0 {
+		return nil, errors.New("invalid page number 0")
+	}
+	if pf.overlay != nil {
+		if data, ok := pf.overlay.Pages[pageNum]; ok {
+			buf := make([]byte, pf.pageSize)
+			copy(buf, data)
+			return buf, nil
+		}
+	}
+	buf := make([]byte, pf.pageSize)
+	off := int64(pageNum-1) * int64(pf.pageSize)
+	n, err := pf.r.ReadAt(buf, off)
+	if n != pf.pageSize {
+		if err == nil {
+			err = fmt.Errorf("short read for page %d: got %d of %d bytes", pageNum, n, pf.pageSize)
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readOverflowChain follows an overflow page chain starting at firstPage
+// until it has collected `remaining` bytes of payload or the chain ends. It
+// returns whatever bytes it managed to collect along with whether the chain
+// was read in full, so truncated/corrupt chains can still be salvaged
+// partially by the caller. visited is scoped to this one chain, not shared
+// across calls: after a DELETE and rebalance, SQLite commonly leaves a stale
+// duplicate cell on a freed page pointing at the same (legitimate) overflow
+// chain a live row's cell also references, and a visited set shared across
+// calls would mistake the second, unrelated encounter for a cycle.
+func (pf *pageFetcher) readOverflowChain(firstPage uint32, remaining int) ([]byte, bool, *CorruptionError) {
+	var out []byte
+	usable := pf.pageSize
+	page := firstPage
+	visited := make(map[uint32]bool)
+	for remaining > 0 {
+		if page == 0 {
+			return out, false, newCorruptionError(PayloadOverflowMissing, "overflow chain ended before payload was complete")
+		}
+		if visited[page] {
+			return out, false, newCorruptionError(PayloadOverflowMissing, fmt.Sprintf("overflow chain cycle detected at page %d", page))
+		}
+		visited[page] = true
+
+		data, err := pf.readPage(page)
+		if err != nil {
+			return out, false, newCorruptionError(PayloadOverflowMissing, err.Error())
+		}
+		if len(data) < 4 {
+			return out, false, newCorruptionError(PayloadOverflowMissing, fmt.Sprintf("overflow page %d too short", page))
+		}
+
+		next := binary.BigEndian.Uint32(data[0:4])
+		chunk := usable - 4
+		if chunk > remaining {
+			chunk = remaining
+		}
+		out = append(out, data[4:4+chunk]...)
+		remaining -= chunk
+		page = next
+	}
+	return out, true, nil
+}
+
+// localPayloadThresholds computes SQLite's min/max local-payload sizes for a
+// table b-tree leaf cell, per the file format spec (U = usable page size).
+func localPayloadThresholds(pageSize int) (minLocal, maxLocal int) {
+	u := pageSize
+	minLocal = ((u-12)*32/255) - 23
+	maxLocal = u - 35
+	return
+}
+
+// localPayloadSize returns how many bytes of payloadSize are stored locally
+// in the cell itself, with the remainder (if any) living in the overflow
+// chain.
+func localPayloadSize(payloadSize, pageSize int) int {
+	minLocal, maxLocal := localPayloadThresholds(pageSize)
+	if payloadSize <= maxLocal {
+		return payloadSize
+	}
+	local := minLocal + (payloadSize-minLocal)%(pageSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	return local
+}
+
+// assemblePayload reads the full record payload for a cell, following the
+// overflow chain when the declared payload size exceeds what fits locally.
+// It returns the (possibly partial) payload bytes and whether the payload is
+// incomplete, so the caller can still attempt a best-effort recovery.
+func (pf *pageFetcher) assemblePayload(cell []byte, headerSize, payloadSize int) ([]byte, bool, *CorruptionError) {
+	local := localPayloadSize(payloadSize, pf.pageSize)
+	if headerSize+local > len(cell) {
+		local = len(cell) - headerSize
+	}
+	if local < 0 {
+		return nil, true, newCorruptionError(TruncatedVarint, "cell shorter than its own header")
+	}
+	localPayload := cell[headerSize : headerSize+local]
+
+	if payloadSize <= local {
+		return localPayload, false, nil
+	}
+
+	payload := make([]byte, local, payloadSize)
+	copy(payload, localPayload)
+
+	ptrOffset := headerSize + local
+	if ptrOffset+4 > len(cell) {
+		return payload, true, newCorruptionError(PayloadOverflowMissing, "missing overflow page pointer")
+	}
+	overflowPage := binary.BigEndian.Uint32(cell[ptrOffset : ptrOffset+4])
+
+	rest, complete, err := pf.readOverflowChain(overflowPage, payloadSize-local)
+	payload = append(payload, rest...)
+	if err != nil || !complete {
+		return payload, true, err
+	}
+	return payload, false, nil
 }
 
 func Run(cfg RepairConfig) error {
-	schemas, err := loadSchemas(cfg.BackupSchemaPath)
+	schemas, err := resolveSchemas(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to load schema: %v", err)
+		return fmt.Errorf("failed to resolve schema: %v", err)
 	}
 
 	outDB, err := initOutputDB(cfg.OutputDBPath, schemas)
@@ -48,7 +222,13 @@ func Run(cfg RepairConfig) error {
 		if err != nil {
 			return fmt.Errorf("failed to get table info for %s: %v", s.Name, err)
 		}
-		tables = append(tables, TableInfo{Name: s.Name, Columns: cols})
+		tables = append(tables, TableInfo{Name: s.Name, Columns: cols, RootPage: s.RootPage})
+	}
+
+	if cfg.RecoverDeleted {
+		if err := createShadowTables(outDB, tables); err != nil {
+			return fmt.Errorf("failed to create shadow tables: %v", err)
+		}
 	}
 
 	f, err := os.Open(cfg.CorruptDBPath)
@@ -57,31 +237,106 @@ func Run(cfg RepairConfig) error {
 	}
 	defer f.Close()
 
-	buffer := make([]byte, cfg.PageSize)
-	pageIndex := 0
-	for {
-		n, err := f.Read(buffer)
-		if err != nil {
-			if err == io.EOF {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat corrupt db: %v", err)
+	}
+	totalPages := int(info.Size() / int64(cfg.PageSize))
+
+	overlay, err := ReplayJournals(cfg.CorruptDBPath, cfg.PageSize)
+	if err != nil {
+		fmt.Printf("Warning: journal replay failed, scanning main file only: %v\n", err)
+		overlay = nil
+	}
+	if pages := maxOverlayPage(overlay); pages > totalPages {
+		totalPages = pages
+	}
+
+	pf := newPageFetcher(f, cfg.PageSize, overlay)
+
+	// reached holds every page number claimed by a known table's b-tree
+	// during TraverseMode's DFS walk, so the fallback linear scan below
+	// skips them instead of re-matching their rows against every table.
+	reached := make(map[uint32]bool)
+	sawIndexPage := false
+
+	// abort is set by a page callback once cfg.Strict and a CorruptionError
+	// have both been seen, and checked after every page so Run can stop as
+	// soon as possible instead of scanning the rest of the file first.
+	var abort error
+
+	if cfg.TraverseMode {
+		for _, t := range tables {
+			if abort != nil {
 				break
 			}
-			return err
+			if t.RootPage <= 0 {
+				continue
+			}
+			owner := []TableInfo{t}
+			traverseTable(pf, uint32(t.RootPage), reached, func(pageIndex int, pageData []byte) {
+				if abort != nil {
+					return
+				}
+				if ce := processPage(pf, pageData, pageIndex, owner, outDB, cfg); ce != nil && cfg.Strict {
+					abort = fmt.Errorf("strict mode: aborting on %v", ce)
+				}
+			})
 		}
-		if n != cfg.PageSize {
-			// incomplete page, stop processing
-			break
+	}
+
+	for pageIndex := 0; abort == nil && pageIndex < totalPages; pageIndex++ {
+		if reached[uint32(pageIndex+1)] {
+			continue
 		}
 
-		err = processPage(buffer, pageIndex, tables, outDB)
+		buffer, err := pf.readPage(uint32(pageIndex + 1))
 		if err != nil {
 			fmt.Printf("Page %d error: %v\n", pageIndex, err)
+			continue
+		}
+
+		if cfg.TraverseMode {
+			if flag, ok := pageFlag(buffer, pageHeaderOffset(pageIndex)); ok && (flag == pageTypeLeafIndex || flag == pageTypeInteriorIndex) {
+				sawIndexPage = true
+			}
+		}
+
+		if ce := processPage(pf, buffer, pageIndex, tables, outDB, cfg); ce != nil && cfg.Strict {
+			abort = fmt.Errorf("strict mode: aborting on %v", ce)
+		}
+	}
+
+	if abort != nil {
+		return abort
+	}
+
+	if cfg.TraverseMode && sawIndexPage {
+		if _, err := outDB.Exec("REINDEX"); err != nil {
+			fmt.Printf("Warning: REINDEX rebuild verification failed: %v\n", err)
 		}
-		pageIndex++
 	}
 
 	return nil
 }
 
+// maxOverlayPage returns the highest page number held in overlay, or 0 if
+// overlay is nil/empty. A replayed WAL can commit pages past the current end
+// of the main file (e.g. a transaction that grew the database), so the page
+// scan needs to cover those too.
+func maxOverlayPage(overlay *WALOverlay) int {
+	if overlay == nil {
+		return 0
+	}
+	max := 0
+	for pageNum := range overlay.Pages {
+		if int(pageNum) > max {
+			max = int(pageNum)
+		}
+	}
+	return max
+}
+
 func loadSchemas(path string) ([]backup.TableSchema, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -92,6 +347,134 @@ func loadSchemas(path string) ([]backup.TableSchema, error) {
 	return schemas, err
 }
 
+// resolveSchemas decides which table schemas to rebuild the output database
+// with. A backup schema is used when present; when it's missing (or
+// SchemalessMode asks for it explicitly), schemas are instead recovered by
+// scanning CorruptDBPath for sqlite_master-shaped rows. When both are
+// available, the recovered schema is cross-checked against the backup and
+// mismatches are reported, but the backup still wins.
+func resolveSchemas(cfg RepairConfig) ([]backup.TableSchema, error) {
+	var backupSchemas []backup.TableSchema
+	if cfg.BackupSchemaPath != "" {
+		s, err := loadSchemas(cfg.BackupSchemaPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load schema: %v", err)
+		}
+		backupSchemas = s
+	}
+
+	if !cfg.SchemalessMode && len(backupSchemas) > 0 {
+		return backupSchemas, nil
+	}
+
+	recovered, err := RecoverSchemas(cfg.CorruptDBPath, cfg.PageSize)
+	if err != nil {
+		if len(backupSchemas) == 0 {
+			return nil, fmt.Errorf("failed to recover schema: %v", err)
+		}
+		fmt.Printf("Warning: schema recovery failed, falling back to backup schema: %v\n", err)
+		return backupSchemas, nil
+	}
+
+	if len(backupSchemas) == 0 {
+		return recovered, nil
+	}
+
+	warnSchemaMismatches(backupSchemas, recovered)
+	return backupSchemas, nil
+}
+
+func warnSchemaMismatches(backupSchemas, recovered []backup.TableSchema) {
+	recoveredByName := make(map[string]backup.TableSchema, len(recovered))
+	for _, s := range recovered {
+		recoveredByName[s.Name] = s
+	}
+	for _, b := range backupSchemas {
+		r, ok := recoveredByName[b.Name]
+		if !ok {
+			fmt.Printf("Warning: table %s present in backup schema but not found by schema recovery\n", b.Name)
+			continue
+		}
+		if strings.TrimSpace(b.SQL) != strings.TrimSpace(r.SQL) {
+			fmt.Printf("Warning: recovered schema for table %s differs from backup schema\n", b.Name)
+		}
+	}
+}
+
+// RecoverSchemas scans every leaf table page of path for rows matching
+// sqlite_master's shape (type, name, tbl_name, rootpage, sql) and rebuilds
+// the set of CREATE TABLE statements found. It's the fallback used when no
+// backup schema exists for the corrupt database.
+func RecoverSchemas(path string, pageSize int) ([]backup.TableSchema, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	totalPages := int(info.Size() / int64(pageSize))
+
+	overlay, err := ReplayJournals(path, pageSize)
+	if err != nil {
+		overlay = nil
+	}
+	if pages := maxOverlayPage(overlay); pages > totalPages {
+		totalPages = pages
+	}
+
+	pf := newPageFetcher(f, pageSize, overlay)
+	best := make(map[string]backup.TableSchema)
+
+	for pageIndex := 0; pageIndex < totalPages; pageIndex++ {
+		buffer, err := pf.readPage(uint32(pageIndex + 1))
+		if err != nil {
+			continue
+		}
+
+		records, _ := readLeafTableRecords(pf, buffer, pageIndex, RepairConfig{})
+		for _, rec := range records {
+			schema, ok := asTableSchema(rec.Values)
+			if !ok {
+				continue
+			}
+			if existing, found := best[schema.Name]; !found || len(schema.SQL) > len(existing.SQL) {
+				best[schema.Name] = schema
+			}
+		}
+	}
+
+	schemas := make([]backup.TableSchema, 0, len(best))
+	for _, s := range best {
+		schemas = append(schemas, s)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+
+	return schemas, nil
+}
+
+// asTableSchema checks whether a parsed record matches the shape of a
+// sqlite_master row describing a table, returning it as a TableSchema if so.
+func asTableSchema(values []interface{}) (backup.TableSchema, bool) {
+	if len(values) != 5 {
+		return backup.TableSchema{}, false
+	}
+	typ, _ := values[0].(string)
+	name, _ := values[1].(string)
+	rootPage, _ := values[3].(int64)
+	sqlText, _ := values[4].(string)
+	if typ != "table" || name == "" || sqlText == "" {
+		return backup.TableSchema{}, false
+	}
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sqlText)), "CREATE TABLE") {
+		return backup.TableSchema{}, false
+	}
+	return backup.TableSchema{Name: name, SQL: sqlText, RootPage: int(rootPage)}, true
+}
+
 func initOutputDB(path string, schemas []backup.TableSchema) (*sql.DB, error) {
 	os.Remove(path)
 	db, err := sql.Open("sqlite3", path)
@@ -109,6 +492,24 @@ func initOutputDB(path string, schemas []backup.TableSchema) (*sql.DB, error) {
 	return db, nil
 }
 
+// createShadowTables creates a __deleted_<table> table alongside each live
+// table, mirroring its columns but with no constraints, so tombstoned rows
+// recovered from freeblocks/slack can be inserted without colliding with
+// PRIMARY KEY/UNIQUE/NOT NULL rules meant for live data.
+func createShadowTables(outDB *sql.DB, tables []TableInfo) error {
+	for _, t := range tables {
+		colDefs := make([]string, len(t.Columns))
+		for i, c := range t.Columns {
+			colDefs[i] = fmt.Sprintf("%s %s", c.Name, c.Type)
+		}
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS __deleted_%s (%s)", t.Name, strings.Join(colDefs, ", "))
+		if _, err := outDB.Exec(query); err != nil {
+			return fmt.Errorf("create shadow table for %s failed: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
 func getTableInfo(db *sql.DB, tableName string) ([]ColumnInfo, error) {
 	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", tableName))
 	if err != nil {
@@ -127,25 +528,34 @@ func getTableInfo(db *sql.DB, tableName string) ([]ColumnInfo, error) {
 		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt_value, &pk); err != nil {
 			return nil, err
 		}
-		cols = append(cols, ColumnInfo{Name: name, Type: ctype, Pk: pk})
+		cols = append(cols, ColumnInfo{Name: name, Type: ctype, Pk: pk, NotNull: notnull != 0})
 	}
 	return cols, nil
 }
 
-func processPage(pageData []byte, pageIndex int, tables []TableInfo, outDB *sql.DB) error {
-	// SQLite B-Tree Leaf Table Page signature is 0x0D
-	headerOffset := 0
-	if pageIndex == 0 {
-		headerOffset = 100
-	}
-
-	if headerOffset >= len(pageData) {
-		return nil
-	}
+// leafRecord is a parsed table-leaf cell: a rowid plus its decoded column
+// values. Truncated is set when the payload had to be cut short, either
+// because its overflow chain couldn't be fully read or a serial type ran
+// past the end of the body.
+type leafRecord struct {
+	RowID      uint64
+	Values     []interface{}
+	Types      []uint64 // serial-type header, used as a record-shape signature
+	Truncated  bool
+	Tombstoned bool // recovered from a freeblock or unused slack, not a live cell
+}
 
-	flag := pageData[headerOffset]
-	if flag != 0x0D {
-		return nil // not a leaf table page
+// readLeafTableRecords walks the cells of a single leaf table b-tree page
+// (flag 0x0D) and parses each one into a leafRecord, following overflow
+// chains as needed. It returns nil for pages that aren't leaf table pages.
+// Every structural problem hit along the way is reported through cfg's
+// Reporter (if any) and printed to stdout; the first one encountered is also
+// returned so Run can abort the whole pass when cfg.Strict is set.
+func readLeafTableRecords(pf *pageFetcher, pageData []byte, pageIndex int, cfg RepairConfig) ([]leafRecord, *CorruptionError) {
+	headerOffset := pageHeaderOffset(pageIndex)
+	flag, ok := pageFlag(pageData, headerOffset)
+	if !ok || flag != pageTypeLeafTable {
+		return nil, nil // not a leaf table page
 	}
 
 	// parse header
@@ -158,6 +568,15 @@ func processPage(pageData []byte, pageIndex int, tables []TableInfo, outDB *sql.
 
 	// traverse cells
 	// Cell pointers start at headerOffset + 8
+	var records []leafRecord
+	var firstErr *CorruptionError
+	note := func(ce *CorruptionError, offset int) {
+		reported := reportCorruption(cfg, ce.At(pageIndex, offset))
+		if firstErr == nil {
+			firstErr = reported
+		}
+	}
+
 	for i := 0; i < int(numCells); i++ {
 		ptrOffset := headerOffset + 8 + i*2
 		if ptrOffset+2 > len(pageData) {
@@ -166,6 +585,7 @@ func processPage(pageData []byte, pageIndex int, tables []TableInfo, outDB *sql.
 		cellOffset := binary.BigEndian.Uint16(pageData[ptrOffset : ptrOffset+2])
 
 		if int(cellOffset) >= len(pageData) {
+			note(newCorruptionError(BadCellPointer, fmt.Sprintf("cell %d pointer %d outside page", i, cellOffset)), ptrOffset)
 			continue
 		}
 
@@ -173,70 +593,282 @@ func processPage(pageData []byte, pageIndex int, tables []TableInfo, outDB *sql.
 		// Cell format:
 		// - Payload Size (Varint)
 		// - Row ID (Varint)
-		// - Payload (Record)
+		// - Payload (Record, possibly spilling into an overflow chain)
 		cell := pageData[cellOffset:]
-		payloadSize, n := readVarint(cell)
-		if n == 0 {
+		payloadSize, n, verr := readVarint(cell)
+		if verr != nil {
+			note(verr, int(cellOffset))
 			continue
 		}
-		rowID, n2 := readVarint(cell[n:])
-		if n2 == 0 {
+		rowID, n2, verr := readVarint(cell[n:])
+		if verr != nil {
+			note(verr, int(cellOffset)+n)
 			continue
 		}
 
-		// check payload is complete within page (no overflow handling for now)
 		totalHeaderSize := n + n2
-		if int(payloadSize) > len(cell)-totalHeaderSize {
-			// overflow page, skip for now
+		payload, truncated, aerr := pf.assemblePayload(cell, totalHeaderSize, int(payloadSize))
+		if aerr != nil {
+			note(aerr, int(cellOffset)+totalHeaderSize)
+			if len(payload) == 0 {
+				continue
+			}
+		}
+
+		values, types, recordTruncated, perr := parseRecordPartial(payload)
+		if perr != nil {
+			note(perr, int(cellOffset)+totalHeaderSize)
 			continue
 		}
+		if recordTruncated {
+			note(newCorruptionError(BadSerialType, "serial type decode failed mid-record; trailing columns dropped"), int(cellOffset)+totalHeaderSize)
+		}
 
-		payload := cell[totalHeaderSize : totalHeaderSize+int(payloadSize)]
-		values, err := parseRecord(payload)
-		if err != nil {
+		records = append(records, leafRecord{RowID: rowID, Values: values, Types: types, Truncated: truncated || recordTruncated})
+	}
+
+	return records, firstErr
+}
+
+// scanFreeblocks walks a leaf table page's freeblock chain (the linked list
+// of gaps left by deleted cells) looking for record fragments in each
+// freeblock's body.
+func scanFreeblocks(pageData []byte, headerOffset int) []leafRecord {
+	if headerOffset+3 > len(pageData) {
+		return nil
+	}
+
+	var records []leafRecord
+	visited := make(map[uint16]bool)
+	next := binary.BigEndian.Uint16(pageData[headerOffset+1 : headerOffset+3])
+	for next != 0 && !visited[next] {
+		visited[next] = true
+		if int(next)+4 > len(pageData) {
+			break
+		}
+		size := binary.BigEndian.Uint16(pageData[int(next)+2 : int(next)+4])
+		bodyStart, bodyEnd := int(next)+4, int(next)+int(size)
+		if bodyEnd > len(pageData) || bodyEnd < bodyStart {
+			break
+		}
+
+		records = append(records, scanGapForRecords(pageData[bodyStart:bodyEnd])...)
+		next = binary.BigEndian.Uint16(pageData[next : int(next)+2])
+	}
+	return records
+}
+
+// scanGapForRecords slides a varint-prefixed record parser across a span of
+// otherwise-unused page bytes, looking for whole, plausible records. It's
+// used both for freeblock bodies and for the slack between the cell pointer
+// array and the cell content area, both of which retain deleted rows' bytes
+// until SQLite happens to overwrite them. Most of that slack is zero-filled,
+// so parseRecordPartial sees plenty of bogus header lengths here - it must
+// reject rather than panic on those, since this scan has no other way to
+// know a given offset is actually a record.
+func scanGapForRecords(data []byte) []leafRecord {
+	var records []leafRecord
+	for start := 0; start < len(data); start++ {
+		rest := data[start:]
+		payloadSize, n, verr := readVarint(rest)
+		if verr != nil || payloadSize == 0 {
+			continue
+		}
+		rowID, n2, verr := readVarint(rest[n:])
+		if verr != nil {
+			continue
+		}
+
+		totalHeaderSize := n + n2
+		if totalHeaderSize+int(payloadSize) > len(rest) {
 			continue
 		}
 
-		// try match table and insert
-		tryRecoverRow(rowID, values, tables, outDB)
+		payload := rest[totalHeaderSize : totalHeaderSize+int(payloadSize)]
+		values, types, truncated, perr := parseRecordPartial(payload)
+		if perr != nil || truncated || len(values) == 0 {
+			continue
+		}
+
+		records = append(records, leafRecord{RowID: rowID, Values: values, Types: types})
+		start += totalHeaderSize + int(payloadSize) - 1 // skip past the record we just matched
 	}
+	return records
+}
 
-	return nil
+// readDeletedLeafRecords scans a leaf table page's freeblock chain and the
+// gap between the cell pointer array and the cell content area for records
+// DELETE left behind. Every result is flagged Tombstoned so callers route it
+// to a shadow table instead of merging it back into live data.
+func readDeletedLeafRecords(pageData []byte, pageIndex int) []leafRecord {
+	headerOffset := pageHeaderOffset(pageIndex)
+	if headerOffset+8 > len(pageData) || pageData[headerOffset] != pageTypeLeafTable {
+		return nil
+	}
+
+	numCells := int(binary.BigEndian.Uint16(pageData[headerOffset+3 : headerOffset+5]))
+	cellContentStart := int(binary.BigEndian.Uint16(pageData[headerOffset+5 : headerOffset+7]))
+	if cellContentStart == 0 {
+		cellContentStart = 65536 // 0 encodes 65536 per the file format spec
+	}
+
+	var records []leafRecord
+	records = append(records, scanFreeblocks(pageData, headerOffset)...)
+
+	gapStart := headerOffset + 8 + 2*numCells
+	if gapStart < cellContentStart && cellContentStart <= len(pageData) {
+		records = append(records, scanGapForRecords(pageData[gapStart:cellContentStart])...)
+	}
+
+	for i := range records {
+		records[i].Tombstoned = true
+	}
+	return records
 }
 
-func tryRecoverRow(rowID uint64, values []interface{}, tables []TableInfo, outDB *sql.DB) {
-	for _, table := range tables {
-		if len(table.Columns) != len(values) {
+// processPage recovers every row it can find on a page into outDB. It
+// returns the first CorruptionError found while parsing, so Run can abort
+// the whole pass when cfg.Strict is set; in the default best-effort mode the
+// caller ignores it and keeps going, since every error was already printed
+// and passed to cfg.Reporter as it was found.
+func processPage(pf *pageFetcher, pageData []byte, pageIndex int, tables []TableInfo, outDB *sql.DB, cfg RepairConfig) *CorruptionError {
+	reportPage(cfg, pageIndex, pageData[pageHeaderOffset(pageIndex)])
+
+	// preferred remembers, per record-shape signature, which table this
+	// page's earlier rows of that shape matched, so later rows with an
+	// identical signature are nudged toward the same table instead of
+	// re-litigating affinity scores from scratch (see bestTable).
+	preferred := make(map[string]string)
+
+	records, firstErr := readLeafTableRecords(pf, pageData, pageIndex, cfg)
+	for _, rec := range records {
+		// Page 1 is always sqlite_master's own b-tree root; its rows never
+		// belong to any table in tables and matching them against one is
+		// meaningless, not a sign of damage - reporting it as
+		// RecordArityMismatch would make -strict abort on every database.
+		if pageIndex == sqliteMasterPageIndex {
 			continue
 		}
+		if ce := tryRecoverRow(rec.RowID, rec.Values, rec.Types, rec.Truncated, tables, outDB, preferred, cfg, pageIndex); ce != nil && firstErr == nil {
+			firstErr = ce
+		}
+	}
+
+	if cfg.RecoverDeleted {
+		for _, rec := range readDeletedLeafRecords(pageData, pageIndex) {
+			tryRecoverDeletedRow(rec.RowID, rec.Values, rec.Types, rec.Truncated, tables, outDB, preferred)
+		}
+	}
 
-		// prepare insert values
-		insertValues := make([]interface{}, len(values))
-		copy(insertValues, values)
+	return firstErr
+}
 
-		// handle INTEGER PRIMARY KEY alias
-		// if table has INTEGER PRIMARY KEY, the corresponding column in Record is usually NULL, need to fill with rowID
-		for i, col := range table.Columns {
-			if col.Pk == 1 && strings.ToUpper(col.Type) == "INTEGER" {
-				if insertValues[i] == nil {
-					insertValues[i] = int64(rowID)
-				}
+// candidateMatches reports whether values could belong to table: no more
+// values than columns, and either an exact column count or a partial record
+// that's allowed to leave trailing columns NULL.
+func candidateMatches(values []interface{}, table TableInfo, partial bool) bool {
+	if len(values) > len(table.Columns) {
+		return false
+	}
+	return len(values) == len(table.Columns) || partial
+}
+
+// buildInsertValues pads values out to table's full column list (missing
+// trailing columns, from a truncated record, become NULL) and fills in the
+// rowid for an INTEGER PRIMARY KEY alias column left NULL by the record.
+func buildInsertValues(rowID uint64, values []interface{}, table TableInfo) []interface{} {
+	insertValues := make([]interface{}, len(table.Columns))
+	copy(insertValues, values)
+
+	for i, col := range table.Columns {
+		if col.Pk == 1 && strings.ToUpper(col.Type) == "INTEGER" {
+			if insertValues[i] == nil {
+				insertValues[i] = int64(rowID)
 			}
 		}
+	}
+	return insertValues
+}
 
-		// build and execute insert statement
-		placeholders := make([]string, len(values))
-		for i := range placeholders {
-			placeholders[i] = "?"
-		}
-		query := fmt.Sprintf("INSERT OR IGNORE INTO %s VALUES (%s)", table.Name, strings.Join(placeholders, ", "))
+func placeholderList(n int) string {
+	placeholders := make([]string, n)
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	return strings.Join(placeholders, ", ")
+}
 
-		_, err := outDB.Exec(query, insertValues...)
-		if err == nil {
-			// Successfully inserted, consider it a successful match (simple strategy)
-			// If multiple tables have the same structure, this may cause false positives,
-			// but for repair purposes, recovering data is the priority
-			return
+// tryRecoverRow picks the candidate table whose column affinities best fit
+// values (see bestTable) and inserts into it. A row that doesn't clear
+// matchThreshold against any table isn't guessed into the nearest
+// almost-fit; it's routed to an __unmatched_<n>cols bucket table instead so
+// no data is silently dropped, and a RecordArityMismatch is reported - same
+// as the structural errors from readLeafTableRecords, it also aborts Run
+// when cfg.Strict is set.
+func tryRecoverRow(rowID uint64, values []interface{}, types []uint64, partial bool, tables []TableInfo, outDB *sql.DB, preferred map[string]string, cfg RepairConfig, pageIndex int) *CorruptionError {
+	table, score, ok := bestTable(values, types, partial, tables, preferred)
+	if ok && score > matchThreshold {
+		insertValues := buildInsertValues(rowID, values, table)
+		query := fmt.Sprintf("INSERT OR IGNORE INTO %s VALUES (%s)", table.Name, placeholderList(len(insertValues)))
+		if _, err := outDB.Exec(query, insertValues...); err == nil {
+			preferred[serialSignature(types)] = table.Name
+			reportRecovered(cfg, table.Name, rowID)
+			return nil
 		}
 	}
+
+	ce := newCorruptionError(RecordArityMismatch, fmt.Sprintf("rowid %d: no table scored above threshold for %d columns", rowID, len(values))).At(pageIndex, -1)
+	reportCorruption(cfg, ce)
+	recoverUnmatchedRow(outDB, rowID, values)
+	return ce
+}
+
+// tryRecoverDeletedRow is tryRecoverRow's counterpart for tombstoned rows: it
+// writes to the __deleted_<table> shadow table so recovered deletions can be
+// reviewed without polluting the live table. Rows that don't clear
+// matchThreshold against any table are dropped rather than bucketed, since
+// the shadow tables are already a review area for uncertain data.
+func tryRecoverDeletedRow(rowID uint64, values []interface{}, types []uint64, partial bool, tables []TableInfo, outDB *sql.DB, preferred map[string]string) {
+	table, score, ok := bestTable(values, types, partial, tables, preferred)
+	if !ok || score <= matchThreshold {
+		return
+	}
+
+	insertValues := buildInsertValues(rowID, values, table)
+	query := fmt.Sprintf("INSERT INTO __deleted_%s VALUES (%s)", table.Name, placeholderList(len(insertValues)))
+	if _, err := outDB.Exec(query, insertValues...); err == nil {
+		preferred[serialSignature(types)] = table.Name
+	}
+}
+
+// ensureUnmatchedTable creates the generic bucket table for n-column rows
+// that didn't clear matchThreshold against any known table, if it doesn't
+// already exist.
+func ensureUnmatchedTable(outDB *sql.DB, n int) error {
+	cols := make([]string, n)
+	for i := range cols {
+		cols[i] = fmt.Sprintf("col%d", i+1)
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS __unmatched_%dcols (rowid INTEGER, %s)", n, strings.Join(cols, ", "))
+	_, err := outDB.Exec(query)
+	return err
+}
+
+// recoverUnmatchedRow inserts values, alongside the rowid they were found
+// under, into the __unmatched_<n>cols bucket table for their arity.
+func recoverUnmatchedRow(outDB *sql.DB, rowID uint64, values []interface{}) {
+	n := len(values)
+	if n == 0 {
+		return
+	}
+	if err := ensureUnmatchedTable(outDB, n); err != nil {
+		return
+	}
+
+	insertValues := make([]interface{}, n+1)
+	insertValues[0] = int64(rowID)
+	copy(insertValues[1:], values)
+
+	query := fmt.Sprintf("INSERT INTO __unmatched_%dcols VALUES (%s)", n, placeholderList(n+1))
+	outDB.Exec(query, insertValues...)
 }
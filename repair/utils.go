@@ -2,103 +2,119 @@ package repair
 
 import (
 	"encoding/binary"
-	"errors"
 	"math"
 )
 
-// readVarint reads a variable-length integer from buf.
-func readVarint(buf []byte) (uint64, int) {
+// readVarint reads a variable-length integer from buf, returning a
+// TruncatedVarint CorruptionError (and n=0) if buf ends before the varint
+// does.
+func readVarint(buf []byte) (uint64, int, *CorruptionError) {
 	var v uint64
 	for i := 0; i < 9; i++ {
 		if i >= len(buf) {
-			return 0, 0
+			return 0, 0, newCorruptionError(TruncatedVarint, "varint ran off the end of its buffer")
 		}
 		b := buf[i]
 		v = (v << 7) | uint64(b&0x7f)
 		if b < 0x80 {
-			return v, i + 1
+			return v, i + 1, nil
 		}
 	}
 	if len(buf) >= 9 {
-		return v, 9
+		return v, 9, nil
 	}
-	return 0, 0
+	return 0, 0, newCorruptionError(TruncatedVarint, "varint ran off the end of its buffer")
 }
 
 func parseRecord(payload []byte) ([]interface{}, error) {
-	headerLen, n := readVarint(payload)
-	if n == 0 || int(headerLen) > len(payload) {
-		return nil, errors.New("invalid header length")
+	values, _, _, err := parseRecordPartial(payload)
+	if err != nil {
+		return values, err
+	}
+	return values, nil
+}
+
+// parseRecordPartial parses as many leading columns of a record as the
+// payload actually contains. If the payload was cut short (e.g. by a
+// truncated overflow chain), it returns the columns it could decode along
+// with truncated=true instead of failing the whole row. It also returns the
+// record's serial-type header, which callers use as a shape signature to
+// recognize repeated records on the same page.
+func parseRecordPartial(payload []byte) (values []interface{}, types []uint64, truncated bool, err *CorruptionError) {
+	headerLen, n, verr := readVarint(payload)
+	if verr != nil {
+		return nil, nil, false, verr
+	}
+	if int(headerLen) < n || int(headerLen) > len(payload) {
+		return nil, nil, false, newCorruptionError(TruncatedVarint, "record header length exceeds payload size")
 	}
 
 	header := payload[n:headerLen]
 	body := payload[headerLen:]
 
-	var types []uint64
 	idx := 0
 	for idx < len(header) {
-		t, n := readVarint(header[idx:])
-		if n == 0 {
+		t, n, verr := readVarint(header[idx:])
+		if verr != nil {
 			break
 		}
 		types = append(types, t)
 		idx += n
 	}
 
-	var values []interface{}
 	bodyIdx := 0
 	for _, t := range types {
-		val, n, err := parseSerialType(t, body[bodyIdx:])
-		if err != nil {
-			return nil, err
+		val, n, serr := parseSerialType(t, body[bodyIdx:])
+		if serr != nil {
+			return values, types, true, nil
 		}
 		values = append(values, val)
 		bodyIdx += n
 	}
 
-	return values, nil
+	return values, types, false, nil
 }
 
-func parseSerialType(t uint64, buf []byte) (interface{}, int, error) {
+func parseSerialType(t uint64, buf []byte) (interface{}, int, *CorruptionError) {
 	switch t {
 	case 0:
 		return nil, 0, nil
 	case 1: // 8-bit signed integer
 		if len(buf) < 1 {
-			return nil, 0, errors.New("buffer too short")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for 8-bit integer")
 		}
 		return int64(int8(buf[0])), 1, nil
 	case 2: // 16-bit signed integer
 		if len(buf) < 2 {
-			return nil, 0, errors.New("buffer too short")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for 16-bit integer")
 		}
 		return int64(int16(binary.BigEndian.Uint16(buf))), 2, nil
 	case 3: // 24-bit signed integer
 		if len(buf) < 3 {
-			return nil, 0, errors.New("buffer too short")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for 24-bit integer")
 		}
 		// Read 3 bytes as big-endian
 		val := int64(int8(buf[0]))<<16 | int64(buf[1])<<8 | int64(buf[2])
 		return val, 3, nil
 	case 4: // 32-bit signed integer
 		if len(buf) < 4 {
-			return nil, 0, errors.New("buffer too short")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for 32-bit integer")
 		}
 		return int64(int32(binary.BigEndian.Uint32(buf))), 4, nil
 	case 5: // 48-bit signed integer
 		if len(buf) < 6 {
-			return nil, 0, errors.New("buffer too short")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for 48-bit integer")
 		}
 		val := int64(int8(buf[0]))<<40 | int64(buf[1])<<32 | int64(binary.BigEndian.Uint32(buf[2:]))
 		return val, 6, nil
 	case 6: // 64-bit signed integer
 		if len(buf) < 8 {
-			return nil, 0, errors.New("buffer too short")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for 64-bit integer")
 		}
 		return int64(binary.BigEndian.Uint64(buf)), 8, nil
 	case 7: // 64-bit IEEE floating point number
 		if len(buf) < 8 {
-			return nil, 0, errors.New("buffer too short")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for floating point number")
 		}
 		return math.Float64frombits(binary.BigEndian.Uint64(buf)), 8, nil
 	case 8:
@@ -110,7 +126,7 @@ func parseSerialType(t uint64, buf []byte) (interface{}, int, error) {
 	if t >= 12 && t%2 == 0 { // BLOB
 		n := int((t - 12) / 2)
 		if len(buf) < n {
-			return nil, 0, errors.New("buffer too short for blob")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for blob")
 		}
 		// Copy blob
 		b := make([]byte, n)
@@ -121,10 +137,10 @@ func parseSerialType(t uint64, buf []byte) (interface{}, int, error) {
 	if t >= 13 && t%2 == 1 { // String
 		n := int((t - 13) / 2)
 		if len(buf) < n {
-			return nil, 0, errors.New("buffer too short for string")
+			return nil, 0, newCorruptionError(BadSerialType, "buffer too short for string")
 		}
 		return string(buf[:n]), n, nil
 	}
 
-	return nil, 0, errors.New("unknown serial type")
+	return nil, 0, newCorruptionError(BadSerialType, "unknown serial type")
 }